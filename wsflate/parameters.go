@@ -0,0 +1,96 @@
+package wsflate
+
+import (
+	"strconv"
+
+	"github.com/gobwas/httphead"
+)
+
+const (
+	paramServerNoContextTakeover = "server_no_context_takeover"
+	paramClientNoContextTakeover = "client_no_context_takeover"
+	paramServerMaxWindowBits     = "server_max_window_bits"
+	paramClientMaxWindowBits     = "client_max_window_bits"
+)
+
+// WindowBits represents the max_window_bits extension parameter. It is
+// defined as a separate type (rather than a plain int) so that "parameter is
+// absent" can be distinguished from "parameter has its default value".
+type WindowBits struct {
+	Defined bool
+	Bits    byte
+}
+
+// Parameters holds the permessage-deflate extension parameters, as they
+// would be negotiated in a Sec-WebSocket-Extensions header.
+//
+// ServerMaxWindowBits and ClientMaxWindowBits are parsed and encoded for
+// interoperability, but are not enforced by RecvExtension or SendExtension:
+// compress/flate always decodes with (and its Writer always compresses
+// with) a 32K window and does not support a smaller one.
+type Parameters struct {
+	ServerNoContextTakeover bool
+	ClientNoContextTakeover bool
+	ServerMaxWindowBits     WindowBits
+	ClientMaxWindowBits     WindowBits
+}
+
+// Option returns the httphead.Option representation of p, suitable for
+// encoding into a Sec-WebSocket-Extensions header value.
+func (p Parameters) Option() httphead.Option {
+	opt := httphead.NewOption(ExtensionName, nil)
+	if p.ServerNoContextTakeover {
+		opt.Parameters.Set([]byte(paramServerNoContextTakeover), nil)
+	}
+	if p.ClientNoContextTakeover {
+		opt.Parameters.Set([]byte(paramClientNoContextTakeover), nil)
+	}
+	if p.ServerMaxWindowBits.Defined {
+		opt.Parameters.Set([]byte(paramServerMaxWindowBits), bitsValue(p.ServerMaxWindowBits.Bits))
+	}
+	if p.ClientMaxWindowBits.Defined {
+		opt.Parameters.Set([]byte(paramClientMaxWindowBits), bitsValue(p.ClientMaxWindowBits.Bits))
+	}
+	return opt
+}
+
+// Parse fills p from a negotiated httphead.Option. It returns false if opt is
+// not a permessage-deflate option.
+func (p *Parameters) Parse(opt httphead.Option) bool {
+	if string(opt.Name) != ExtensionName {
+		return false
+	}
+	*p = Parameters{}
+	opt.Parameters.ForEach(func(key, value []byte) bool {
+		switch string(key) {
+		case paramServerNoContextTakeover:
+			p.ServerNoContextTakeover = true
+		case paramClientNoContextTakeover:
+			p.ClientNoContextTakeover = true
+		case paramServerMaxWindowBits:
+			p.ServerMaxWindowBits = parseBits(value)
+		case paramClientMaxWindowBits:
+			p.ClientMaxWindowBits = parseBits(value)
+		}
+		return true
+	})
+	return true
+}
+
+func bitsValue(bits byte) []byte {
+	if bits == 0 {
+		return nil
+	}
+	return strconv.AppendInt(nil, int64(bits), 10)
+}
+
+func parseBits(value []byte) WindowBits {
+	if len(value) == 0 {
+		return WindowBits{Defined: true}
+	}
+	n, err := strconv.ParseUint(string(value), 10, 8)
+	if err != nil {
+		return WindowBits{Defined: true}
+	}
+	return WindowBits{Defined: true, Bits: byte(n)}
+}
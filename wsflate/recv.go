@@ -0,0 +1,195 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/dinhngocdiep/ws"
+	"github.com/dinhngocdiep/ws/wsutil"
+)
+
+// ErrUnexpectedRSV1 is returned by RecvExtension.UnsetBits when a control
+// frame or a continuation frame carries the RSV1 bit, which is forbidden by
+// RFC 7692.
+var ErrUnexpectedRSV1 = errors.New("wsflate: unexpected RSV1 bit")
+
+// tail is the 4 bytes that a permessage-deflate sender strips from the end
+// of a DEFLATE block; they must be restored before handing a message's
+// compressed bytes to flate.Reader.
+var tail = [4]byte{0x00, 0x00, 0xff, 0xff}
+
+// maxDict is the maximum size of the preset dictionary RecvExtension keeps
+// around for context takeover, matching DEFLATE's 32K window.
+const maxDict = 32768
+
+// RecvExtension implements wsutil.RecvExtension and wsutil.FrameDecompressor
+// for the receiving side of permessage-deflate (RFC 7692).
+//
+// Per RFC 7692, a WebSocket frame boundary carries no meaning in the
+// underlying DEFLATE bit stream, so RecvExtension buffers a message's
+// frames as they arrive and only decodes once the Fin frame completes the
+// message; Decompress returns an empty reader for every non-final frame.
+// The resulting flate.Reader is (re)created once per message, not once per
+// connection: when context takeover is negotiated for the side
+// RecvExtension reads, the trailing bytes decompressed for a message are
+// kept as that flate.Reader's preset dictionary for the next message,
+// mirroring how a real DEFLATE sliding window would carry over; otherwise
+// the dictionary is dropped between messages.
+//
+// A RecvExtension must not be used concurrently from multiple goroutines.
+type RecvExtension struct {
+	Parameters Parameters
+
+	// MaxMessageSize, when non-zero, caps the number of bytes a single
+	// message may decompress to, returning wsutil.ErrMessageTooLarge once
+	// exceeded. A wsutil.Reader's own MaxMessageSize runs on the wire
+	// bytes of each frame before RecvExtension ever sees them, so by
+	// itself it only bounds compressed size; a small compressed frame can
+	// still inflate to unbounded memory once this extension decodes it.
+	// Set MaxMessageSize here too to bound the decompressed size as well.
+	MaxMessageSize int64
+
+	// noContextTakeover tells whether the dictionary must be discarded
+	// between messages instead of being carried over.
+	noContextTakeover bool
+
+	compressed bool // whether the message currently being read is compressed.
+	buf        bytes.Buffer
+	fr         io.ReadCloser
+	dict       []byte // trailing decompressed bytes, reused as next message's preset dictionary.
+	inflated   int64  // decompressed bytes produced so far for the message currently being read.
+}
+
+// NewRecvExtension creates a RecvExtension from negotiated parameters.
+// isServer tells which side of the connection this RecvExtension reads for,
+// since the *_no_context_takeover parameter that governs reuse of the
+// dictionary is the one describing the sender: server_no_context_takeover
+// when reading on the client, client_no_context_takeover when reading on
+// the server.
+func NewRecvExtension(p Parameters, isServer bool) *RecvExtension {
+	e := &RecvExtension{Parameters: p}
+	if isServer {
+		e.noContextTakeover = p.ClientNoContextTakeover
+	} else {
+		e.noContextTakeover = p.ServerNoContextTakeover
+	}
+	return e
+}
+
+// UnsetBits implements wsutil.RecvExtension. It clears RSV1 on the first
+// frame of a compressed message and remembers, until the message's Fin
+// frame, that its frames must be decompressed.
+func (e *RecvExtension) UnsetBits(h ws.Header) (ws.Header, error) {
+	const bitRSV1 = 0x4
+
+	rsv1 := h.Rsv&bitRSV1 != 0
+	switch {
+	case h.OpCode.IsControl():
+		if rsv1 {
+			return h, ErrUnexpectedRSV1
+		}
+	case h.OpCode == ws.OpContinuation:
+		if rsv1 {
+			return h, ErrUnexpectedRSV1
+		}
+	case rsv1:
+		e.compressed = true
+		// If the previous compressed message was abandoned unread (e.g. via
+		// Reader.Discard()), finish inflating it first when context takeover
+		// applies, so that its bytes still reach the dictionary the sender
+		// compressed this message against. For a message that was read to
+		// its end this reads nothing.
+		if e.fr != nil && !e.noContextTakeover {
+			e.inflated = 0
+			if _, err := io.Copy(ioutil.Discard, &messageReader{e: e}); err != nil {
+				return h, err
+			}
+		}
+		// Starting a new compressed message: drop whatever the buffer
+		// still holds. Ordinarily that is nothing, since Decompress's
+		// flate.Reader consumes buf as it decodes. But if the previous
+		// compressed message was abandoned — e.g. via Reader.Discard(),
+		// which never reads from the io.Reader Decompress hands back for
+		// the Fin frame — its undrained compressed bytes would otherwise
+		// still be sitting at the front of buf, and the next Reset would
+		// replay them ahead of this message's bytes.
+		e.buf.Reset()
+		e.inflated = 0
+		h.Rsv &^= bitRSV1
+	}
+	return h, nil
+}
+
+// Decompress implements wsutil.FrameDecompressor. It buffers r's bytes as
+// part of the message currently being assembled. For every frame but the
+// last it returns a reader that is already at EOF, since nothing can be
+// decoded until the whole message has arrived; for the message's Fin frame
+// it returns a reader over the fully inflated message.
+func (e *RecvExtension) Decompress(h ws.Header, r io.Reader) (io.Reader, error) {
+	if !e.compressed {
+		return r, nil
+	}
+	if _, err := io.Copy(&e.buf, r); err != nil {
+		return nil, err
+	}
+	if !h.Fin {
+		return eofReader{}, nil
+	}
+	e.compressed = false
+	e.buf.Write(tail[:])
+
+	var dict []byte
+	if !e.noContextTakeover {
+		dict = e.dict
+	}
+	if e.fr == nil {
+		e.fr = flate.NewReaderDict(&e.buf, dict)
+	} else if err := e.fr.(flate.Resetter).Reset(&e.buf, dict); err != nil {
+		return nil, err
+	}
+	return &messageReader{e: e}, nil
+}
+
+// messageReader adapts e.fr for the lifetime of one message: it launders
+// the io.ErrUnexpectedEOF that flate.Reader returns once it has consumed
+// the message's trailing sync-flush bytes and tries to read a further
+// DEFLATE block header that will never come, turning it back into the
+// plain io.EOF callers expect at the end of a message's payload. It also
+// feeds every decompressed byte into e's dictionary for the next message,
+// when context takeover applies, and into e.MaxMessageSize's accounting.
+type messageReader struct {
+	e *RecvExtension
+}
+
+func (m *messageReader) Read(p []byte) (n int, err error) {
+	n, err = m.e.fr.Read(p)
+	if n > 0 {
+		m.e.appendDict(p[:n])
+		m.e.inflated += int64(n)
+		if max := m.e.MaxMessageSize; max > 0 && m.e.inflated > max {
+			return n, wsutil.ErrMessageTooLarge
+		}
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (e *RecvExtension) appendDict(p []byte) {
+	if e.noContextTakeover {
+		return
+	}
+	e.dict = append(e.dict, p...)
+	if len(e.dict) > maxDict {
+		e.dict = append(e.dict[:0], e.dict[len(e.dict)-maxDict:]...)
+	}
+}
+
+// eofReader is an io.Reader that is always at EOF.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
@@ -0,0 +1,148 @@
+package wsflate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/dinhngocdiep/ws"
+	"github.com/dinhngocdiep/ws/wsutil"
+)
+
+// repeatCompress returns n copies of b compressed as a single message; the
+// repetition ratio is what makes the compressed frame much smaller than
+// what it decompresses to.
+func repeatCompress(t *testing.T, se *SendExtension, b byte, n int) []byte {
+	t.Helper()
+	return compressMessage(t, se, bytes.Repeat([]byte{b}, n))
+}
+
+// compressMessage runs payload through se.Compress and returns the
+// compressed bytes, as a real sender would produce them for a single-frame
+// message.
+func compressMessage(t *testing.T, se *SendExtension, payload []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w := se.Compress(&compressed)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("compress write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("compress close: %v", err)
+	}
+	return compressed.Bytes()
+}
+
+// frameCompressedMessage wraps already-compressed bytes in a single
+// fin, RSV1-set WS frame, the way a permessage-deflate sender's
+// SendExtension.SetBits would mark it (there being no wsutil.Writer
+// integration in this package to do so end-to-end).
+func frameCompressedMessage(payload []byte) []byte {
+	const bitRSV1 = 0x4
+	b0 := byte(ws.OpText) | 0x80 | (bitRSV1 << 4)
+	b := []byte{b0}
+
+	n := len(payload)
+	switch {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= 0xffff:
+		b = append(b, 126, byte(n>>8), byte(n))
+	default:
+		b = append(b, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, payload...)
+}
+
+func TestSendRecvRoundTrip(t *testing.T) {
+	params := Parameters{}
+	se := NewSendExtension(params, true)
+	re := NewRecvExtension(params, false)
+
+	var wire bytes.Buffer
+	messages := []string{"hello", "world, compressed over the wire"}
+	for _, msg := range messages {
+		wire.Write(frameCompressedMessage(compressMessage(t, se, []byte(msg))))
+	}
+
+	r := wsutil.NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+	r.Extensions = []wsutil.RecvExtension{re}
+
+	for _, want := range messages {
+		if _, err := r.NextFrame(); err != nil {
+			t.Fatalf("NextFrame: %v", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// TestRecvExtensionDiscardDoesNotCorruptNextMessage is a regression test:
+// discarding a compressed message via Reader.Discard() — the library's own
+// documented way to skip a message — used to leave that message's
+// undrained compressed bytes in RecvExtension's buffer, so the following
+// message decoded as a replay of the discarded one instead of its own
+// content.
+func TestRecvExtensionDiscardDoesNotCorruptNextMessage(t *testing.T) {
+	params := Parameters{}
+	se := NewSendExtension(params, true)
+	re := NewRecvExtension(params, false)
+
+	var wire bytes.Buffer
+	wire.Write(frameCompressedMessage(compressMessage(t, se, []byte("message A, discarded unread"))))
+	wire.Write(frameCompressedMessage(compressMessage(t, se, []byte("message B"))))
+
+	r := wsutil.NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+	r.Extensions = []wsutil.RecvExtension{re}
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame (A): %v", err)
+	}
+	if err := r.Discard(); err != nil {
+		t.Fatalf("Discard (A): %v", err)
+	}
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame (B): %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll (B): %v", err)
+	}
+	if want := "message B"; string(got) != want {
+		t.Fatalf("message B corrupted by discarding A: got %q, want %q", got, want)
+	}
+}
+
+// TestRecvExtensionMaxMessageSize checks that a highly compressible message
+// decompressing past MaxMessageSize is rejected, even though its on-wire
+// (compressed) size is tiny — the decompression-bomb gap left by
+// wsutil.Reader.MaxMessageSize only ever seeing compressed bytes.
+func TestRecvExtensionMaxMessageSize(t *testing.T) {
+	params := Parameters{}
+	se := NewSendExtension(params, true)
+	re := NewRecvExtension(params, false)
+	re.MaxMessageSize = 1024
+
+	compressed := repeatCompress(t, se, 'a', 1<<20)
+	var wire bytes.Buffer
+	wire.Write(frameCompressedMessage(compressed))
+
+	r := wsutil.NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+	r.Extensions = []wsutil.RecvExtension{re}
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err != wsutil.ErrMessageTooLarge {
+		t.Fatalf("got err %v, want wsutil.ErrMessageTooLarge", err)
+	}
+}
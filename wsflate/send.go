@@ -0,0 +1,153 @@
+package wsflate
+
+import (
+	"compress/flate"
+	"io"
+
+	"github.com/dinhngocdiep/ws"
+)
+
+// SendExtension implements wsutil.SendExtension for the sending side of
+// permessage-deflate (RFC 7692). Like RecvExtension, a single SendExtension
+// is meant to be reused across all messages written to one connection so
+// that context takeover can work when negotiated: the trailing bytes of
+// each compressed message are kept as the preset dictionary for the next
+// one.
+//
+// A SendExtension must not be used concurrently from multiple goroutines.
+type SendExtension struct {
+	Parameters Parameters
+
+	noContextTakeover bool
+	compressing       bool // whether the message currently being written is compressed.
+	fw                *flate.Writer
+	trim              *trimWriter
+	dict              []byte // trailing bytes written to the last message, reused as the next message's preset dictionary.
+}
+
+// NewSendExtension creates a SendExtension from negotiated parameters.
+// isServer tells which side of the connection this SendExtension writes
+// for: server_no_context_takeover governs the server's writer, while
+// client_no_context_takeover governs the client's.
+func NewSendExtension(p Parameters, isServer bool) *SendExtension {
+	e := &SendExtension{Parameters: p}
+	if isServer {
+		e.noContextTakeover = p.ServerNoContextTakeover
+	} else {
+		e.noContextTakeover = p.ClientNoContextTakeover
+	}
+	return e
+}
+
+// SetBits implements wsutil.SendExtension. It sets RSV1 on the first frame
+// of a message that is being compressed.
+func (e *SendExtension) SetBits(h ws.Header) (ws.Header, error) {
+	const bitRSV1 = 0x4
+
+	if e.compressing && !h.OpCode.IsControl() && h.OpCode != ws.OpContinuation {
+		h.Rsv |= bitRSV1
+	}
+	return h, nil
+}
+
+// Compress wraps w so that bytes written to the returned writer are
+// compressed per permessage-deflate: the trailing 4-byte DEFLATE
+// sync-flush marker is stripped before it reaches w, since the receiver is
+// expected to restore it. Close must be called once the whole message has
+// been written; it also clears the extension's compressing state so that
+// SetBits does not set RSV1 on a later message that is not compressed.
+func (e *SendExtension) Compress(w io.Writer) io.WriteCloser {
+	e.compressing = true
+	if e.trim == nil {
+		e.trim = new(trimWriter)
+	}
+	e.trim.reset(w)
+
+	if e.noContextTakeover {
+		// No dictionary to carry over, so the writer can simply be reused
+		// across messages: flate.Writer.Reset takes no dictionary argument.
+		if e.fw == nil {
+			// flate.BestCompression is always a valid level, so the error
+			// return is always nil.
+			e.fw, _ = flate.NewWriter(e.trim, flate.BestCompression)
+		} else {
+			e.fw.Reset(e.trim)
+		}
+	} else {
+		// flate.Writer has no way to update a preset dictionary on an
+		// existing writer, so context takeover requires a fresh one each
+		// message, seeded with the previous message's trailing bytes.
+		e.fw, _ = flate.NewWriterDict(e.trim, flate.BestCompression, e.dict)
+	}
+	return &messageSink{e: e}
+}
+
+// messageSink is returned from Compress; writing to it compresses into the
+// extension's flate.Writer, and Close flushes the compressed message and
+// resets the extension's per-message state.
+type messageSink struct {
+	e *SendExtension
+}
+
+func (s *messageSink) Write(p []byte) (int, error) {
+	n, err := s.e.fw.Write(p)
+	if n > 0 {
+		s.e.appendDict(p[:n])
+	}
+	return n, err
+}
+
+func (s *messageSink) Close() error {
+	if err := s.e.fw.Flush(); err != nil {
+		return err
+	}
+	s.e.trim.dropTail()
+	s.e.compressing = false
+	return nil
+}
+
+// appendDict records p as (part of) the dictionary to seed the next
+// message's writer with, when context takeover is in effect.
+func (e *SendExtension) appendDict(p []byte) {
+	if e.noContextTakeover {
+		return
+	}
+	e.dict = append(e.dict, p...)
+	if len(e.dict) > maxDict {
+		e.dict = append(e.dict[:0], e.dict[len(e.dict)-maxDict:]...)
+	}
+}
+
+// trimWriter withholds the last 4 bytes written to it, so that they can be
+// dropped instead of forwarded to the underlying writer once the caller
+// knows them to be the DEFLATE sync-flush marker that permessage-deflate
+// strips from the wire.
+type trimWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (t *trimWriter) reset(w io.Writer) {
+	t.w = w
+	t.buf = t.buf[:0]
+}
+
+func (t *trimWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	t.buf = append(t.buf, p...)
+	if len(t.buf) <= len(tail) {
+		return n, nil
+	}
+	flush := t.buf[:len(t.buf)-len(tail)]
+	if _, err := t.w.Write(flush); err != nil {
+		return n, err
+	}
+	t.buf = append(t.buf[:0], t.buf[len(flush):]...)
+	return n, nil
+}
+
+// dropTail discards whatever remains buffered, which is exactly the
+// trailing sync-flush marker (or, for very small messages, a prefix of it).
+func (t *trimWriter) dropTail() {
+	t.buf = t.buf[:0]
+}
@@ -0,0 +1,17 @@
+// Package wsflate implements permessage-deflate (RFC 7692) as a
+// RecvExtension/SendExtension for wsutil.
+//
+// This package covers the framing side of the extension: clearing/setting
+// RSV1 on data frames and compressing/decompressing their payload.
+// Parameters.Option and Parameters.Parse encode and decode an already
+// decided set of parameters to and from a Sec-WebSocket-Extensions header
+// value, but this package does not itself negotiate which Parameters to
+// accept from a peer's offer, and SendExtension.SetBits/Compress are not
+// wired into any wsutil.Writer — there is no such writer in this module for
+// them to integrate with. Negotiation and the write-side integration are
+// left to the caller.
+package wsflate
+
+// ExtensionName is the name used to negotiate the permessage-deflate
+// extension in the Sec-WebSocket-Extensions header, as defined by RFC 7692.
+const ExtensionName = "permessage-deflate"
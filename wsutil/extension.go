@@ -0,0 +1,30 @@
+package wsutil
+
+import (
+	"io"
+
+	"github.com/dinhngocdiep/ws"
+)
+
+// RecvExtension is an interface for clearing/checking header RSV bits
+// (which is in the responsibility of an extension implementation) on the
+// receive side.
+type RecvExtension interface {
+	UnsetBits(ws.Header) (ws.Header, error)
+}
+
+// SendExtension is an interface for setting header RSV bits on the send
+// side, mirroring RecvExtension.
+type SendExtension interface {
+	SetBits(ws.Header) (ws.Header, error)
+}
+
+// FrameDecompressor may optionally be implemented by a RecvExtension that
+// needs to transform a frame's payload reader, such as a decompressing
+// extension. When a RecvExtension implements FrameDecompressor, Reader.
+// NextFrame calls Decompress for every non-control frame of a message right
+// after UnsetBits, before any further checks (e.g. CheckUTF8) are applied to
+// the frame's bytes.
+type FrameDecompressor interface {
+	Decompress(hdr ws.Header, r io.Reader) (io.Reader, error)
+}
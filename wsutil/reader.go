@@ -17,6 +17,17 @@ var ErrNoFrameAdvance = errors.New("no frame advance")
 // MaxFrameSize was being read.
 var ErrFrameTooLarge = errors.New("frame too large")
 
+// ErrMessageTooLarge indicates that a message of length higher than
+// MaxMessageSize was being read.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ErrReaderClosed is returned by the io.Reader returned from
+// NextReaderFromReader (or NextFrame's caller holding onto a previous
+// frame's reader) once the parent Reader has advanced past the frame that
+// io.Reader was reading. It is never returned by the plain NextReader,
+// since that helper has no Reader of its own for a later call to share.
+var ErrReaderClosed = errors.New("wsutil: reader advanced past its frame")
+
 // FrameHandlerFunc handles parsed frame header and its body represented by
 // io.Reader.
 //
@@ -42,9 +53,17 @@ type Reader struct {
 	// bytes are not valid UTF-8 sequence, ErrInvalidUTF8 returned.
 	CheckUTF8 bool
 
+	// CheckUTF8Mode controls when CheckUTF8 reports invalid UTF-8: either
+	// once the whole message has been received (UTF8Deferred, the zero
+	// value and historical behavior) or as soon as an invalid byte is read
+	// (UTF8Streaming). It has no effect unless CheckUTF8 is true.
+	CheckUTF8Mode CheckUTF8Mode
+
 	// Extensions is a list of negotiated extensions for reader Source.
 	// It is used to meet the specs and clear appropriate bits in fragment
-	// header RSV segment.
+	// header RSV segment. An extension that also implements
+	// FrameDecompressor (such as wsflate.RecvExtension) additionally gets a
+	// chance to transform the frame's payload reader, e.g. to decompress it.
 	Extensions []RecvExtension
 
 	// MaxFrameSize controls the maximum frame size in bytes
@@ -54,15 +73,25 @@ type Reader struct {
 	// Not setting this field means there is no limit.
 	MaxFrameSize int64
 
+	// MaxMessageSize controls the maximum size in bytes of a whole message,
+	// accumulated across all frames that make up that message. A message
+	// exceeding that size will return a ErrMessageTooLarge to the
+	// application.
+	//
+	// Not setting this field means there is no limit.
+	MaxMessageSize int64
+
 	OnContinuation FrameHandlerFunc
 	OnIntermediate FrameHandlerFunc
 
-	opCode ws.OpCode                  // Used to store message op code on fragmentation.
-	frame  io.Reader                  // Used to as frame reader.
-	raw    io.LimitedReader           // Used to discard frames without cipher.
-	utf8   UTF8Reader                 // Used to check UTF8 sequences if CheckUTF8 is true.
-	tmp    [ws.MaxHeaderSize - 2]byte // Used for reading headers.
-	cr     *CipherReader              // Used by NextFrame() to unmask frame payload.
+	opCode     ws.OpCode                  // Used to store message op code on fragmentation.
+	frame      io.Reader                  // Used to as frame reader.
+	raw        io.LimitedReader           // Used to discard frames without cipher.
+	utf8       UTF8Reader                 // Used to check UTF8 sequences if CheckUTF8 is true.
+	tmp        [ws.MaxHeaderSize - 2]byte // Used for reading headers.
+	cr         *CipherReader              // Used by NextFrame() to unmask frame payload.
+	readLength int64                      // Accumulated length of the current message, reset on Fin.
+	seq        uint32                     // Bumped on every NextFrame() call; used to detect stale readers.
 }
 
 // NewReader creates new frame reader that reads from r keeping given state to
@@ -130,8 +159,11 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		r.resetFragment()
 
 	case r.CheckUTF8 && !r.utf8.Valid():
-		// NOTE: check utf8 only when full message received, since partial
-		// reads may be invalid.
+		// In UTF8Deferred mode this is what catches invalid bytes at all.
+		// In UTF8Streaming mode r.utf8.Read() already returned
+		// ErrInvalidUTF8 as soon as an invalid byte was seen, so this only
+		// still fires here for a message that ends with a truncated
+		// multi-byte sequence.
 		n = r.utf8.Accepted()
 		err = ErrInvalidUTF8
 
@@ -168,6 +200,8 @@ func (r *Reader) Discard() (err error) {
 // Note that next NextFrame() call must be done after receiving or discarding
 // all current message bytes.
 func (r *Reader) NextFrame() (hdr ws.Header, err error) {
+	r.seq++
+
 	hdr, err = r.readHeader(r.Source)
 	if err == io.EOF && r.fragmented() {
 		// If we are in fragmented state EOF means that is was totally
@@ -190,6 +224,14 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 		return hdr, ErrFrameTooLarge
 	}
 
+	if n := r.MaxMessageSize; n > 0 && !hdr.OpCode.IsControl() {
+		readLength, ok := setReadRemaining(r.readLength, hdr.Length)
+		if !ok || readLength > n {
+			return hdr, ErrMessageTooLarge
+		}
+		r.readLength = readLength
+	}
+
 	// Save raw reader to use it on discarding frame without ciphering and
 	// other streaming checks.
 	r.raw = io.LimitedReader{
@@ -212,6 +254,12 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 		if err != nil {
 			return hdr, err
 		}
+		if d, ok := x.(FrameDecompressor); ok && !hdr.OpCode.IsControl() {
+			frame, err = d.Decompress(hdr, frame)
+			if err != nil {
+				return hdr, err
+			}
+		}
 	}
 
 	if r.fragmented() {
@@ -230,6 +278,7 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 	}
 	if r.CheckUTF8 && (hdr.OpCode == ws.OpText || (r.fragmented() && r.opCode == ws.OpText)) {
 		r.utf8.Source = frame
+		r.utf8.Mode = r.CheckUTF8Mode
 		frame = &r.utf8
 	}
 
@@ -244,6 +293,7 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 
 	if hdr.Fin {
 		r.State = r.State.Clear(ws.StateFragmented)
+		r.readLength = 0
 	} else {
 		r.State = r.State.Set(ws.StateFragmented)
 	}
@@ -251,6 +301,26 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 	return hdr, err
 }
 
+// MessageLength returns the number of bytes accumulated so far for the
+// message currently being read, across all of its frames. It is only
+// meaningful when MaxMessageSize is set, and is reset to zero as soon as
+// NextFrame returns a message's final (Fin) frame, so it never includes
+// that frame: for a single-frame message it is zero throughout.
+func (r *Reader) MessageLength() int64 {
+	return r.readLength
+}
+
+// setReadRemaining adds length to total and reports whether the result is a
+// valid, non-negative int64. It guards against int64 overflow on pathological
+// (but otherwise well-formed) frame headers.
+func setReadRemaining(total, length int64) (n int64, ok bool) {
+	n = total + length
+	if n < total || n < length {
+		return 0, false
+	}
+	return n, true
+}
+
 func (r *Reader) fragmented() bool {
 	return r.State.Fragmented()
 }
@@ -267,6 +337,20 @@ func (r *Reader) reset() {
 	r.frame = nil
 	r.utf8 = UTF8Reader{}
 	r.opCode = 0
+	r.readLength = 0
+}
+
+// readFull reads exactly len(p) bytes from in into p. Unlike io.ReadFull, it
+// treats any EOF as unexpected: it is only ever called once the first two
+// bytes of a header have already been read, so a subsequent bare io.EOF
+// would misleadingly look like a clean, frame-boundary stream end rather
+// than the truncated frame it actually is.
+func readFull(in io.Reader, p []byte) error {
+	_, err := io.ReadFull(in, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
 }
 
 // readHeader reads a frame header from in.
@@ -319,8 +403,7 @@ func (r *Reader) readHeader(in io.Reader) (h ws.Header, err error) {
 	// Increase len of bts to extra bytes need to read.
 	// Overwrite first 2 bytes that was read before.
 	bts = bts[:extra]
-	_, err = io.ReadFull(in, bts)
-	if err != nil {
+	if err = readFull(in, bts); err != nil {
 		return h, err
 	}
 
@@ -349,6 +432,13 @@ func (r *Reader) readHeader(in io.Reader) (h ws.Header, err error) {
 // describes the message and io.Reader to read message's payload. It returns
 // non-nil error when it is not possible to read message's initial frame.
 //
+// NextReader builds its own Reader around r and s on every call, so the
+// io.Reader it returns cannot detect a caller reusing it after a later
+// NextReader call on the same r: there is nothing for it to compare itself
+// against across calls. Use NextReaderFromReader, backed by a Reader the
+// caller constructs once and keeps across calls, when that guard needs to
+// be able to fire.
+//
 // Note that next NextReader() on the same r should be done after reading all
 // bytes from previously returned io.Reader. For more performant way to discard
 // message use Reader and its Discard() method.
@@ -359,15 +449,86 @@ func (r *Reader) readHeader(in io.Reader) (h ws.Header, err error) {
 // eventually remaining part of text/binary frame with fin "true" – with
 // NextReader() the ping frame will be dropped without any notice. To handle
 // this rare, but possible situation (and if you do not know exactly which
-// frames peer could send), you could use Reader with OnIntermediate field set.
+// frames peer could send), you could use Reader with OnIntermediate field
+// set, or call NextReaderWithHandlers instead.
 func NextReader(r io.Reader, s ws.State) (ws.Header, io.Reader, error) {
+	return NextReaderWithHandlers(r, s, nil, nil)
+}
+
+// NextReaderWithHandlers is the same as NextReader, but additionally calls
+// onIntermediate for every ping/pong/close frame interleaved between the
+// fragments of the message being read, and onContinuation for every
+// continuation frame, letting casual callers observe them without
+// allocating and configuring a full Reader themselves.
+//
+// Either handler may be nil, in which case the corresponding frames are
+// dropped, same as in NextReader.
+func NextReaderWithHandlers(
+	r io.Reader, s ws.State,
+	onIntermediate, onContinuation FrameHandlerFunc,
+) (ws.Header, io.Reader, error) {
 	rd := &Reader{
 		Source: r,
 		State:  s,
 	}
+	return nextReader(rd, onIntermediate, onContinuation)
+}
+
+// NextReaderFromReader is the same as NextReader, but it advances rd, a
+// Reader the caller constructs (e.g. via NewReader) and keeps across calls,
+// instead of building a private one from a raw io.Reader and ws.State.
+// Because rd's seq counter then persists across calls, the io.Reader
+// NextReaderFromReader returns can detect rd having advanced past its frame
+// — because NextReaderFromReader or NextFrame was called again on rd
+// (directly, or by Discard skipping further fragments) before the
+// previously returned io.Reader was fully drained — and returns
+// ErrReaderClosed instead of silently observing bytes of the new frame.
+// Discard of a single-frame message does not advance rd's seq counter; a
+// read from the previously returned io.Reader then returns
+// ErrNoFrameAdvance.
+func NextReaderFromReader(rd *Reader) (ws.Header, io.Reader, error) {
+	return NextReaderFromReaderWithHandlers(rd, nil, nil)
+}
+
+// NextReaderFromReaderWithHandlers is the same as NextReaderFromReader, but
+// additionally calls onIntermediate and onContinuation as
+// NextReaderWithHandlers does.
+func NextReaderFromReaderWithHandlers(
+	rd *Reader,
+	onIntermediate, onContinuation FrameHandlerFunc,
+) (ws.Header, io.Reader, error) {
+	return nextReader(rd, onIntermediate, onContinuation)
+}
+
+func nextReader(
+	rd *Reader,
+	onIntermediate, onContinuation FrameHandlerFunc,
+) (ws.Header, io.Reader, error) {
+	rd.OnIntermediate = onIntermediate
+	rd.OnContinuation = onContinuation
 	header, err := rd.NextFrame()
 	if err != nil {
 		return header, nil, err
 	}
-	return header, rd, nil
+	return header, &seqReader{r: rd, seq: rd.seq}, nil
+}
+
+// seqReader wraps a Reader's seq counter around its Read() method, so that
+// stale readers handed out by NextReader (and similar helpers) return
+// ErrReaderClosed instead of silently observing bytes of a frame that the
+// parent Reader has since advanced past.
+type seqReader struct {
+	r   *Reader
+	seq uint32
+}
+
+func (s *seqReader) Read(p []byte) (n int, err error) {
+	if s.r.seq != s.seq {
+		return 0, ErrReaderClosed
+	}
+	n, err = s.r.Read(p)
+	// Reader.Read() may have advanced to a continuation frame of the same
+	// message on our behalf; track that so the next Read() is not rejected.
+	s.seq = s.r.seq
+	return n, err
 }
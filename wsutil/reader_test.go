@@ -0,0 +1,210 @@
+package wsutil
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/dinhngocdiep/ws"
+)
+
+// buildFrame hand-encodes a single RFC6455 frame header plus payload,
+// mirroring exactly what readHeader expects to parse. mask, when true,
+// XOR-masks the payload with a fixed key so that tests exercising a Reader
+// that does not set SkipHeaderCheck (and so goes through ws.CheckHeader's
+// masking requirements) have a realistic frame to read.
+func buildFrame(fin bool, opcode ws.OpCode, payload []byte, mask bool) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	b := []byte{b0}
+
+	n := len(payload)
+	var b1 byte
+	if mask {
+		b1 = 0x80
+	}
+	switch {
+	case n < 126:
+		b = append(b, b1|byte(n))
+	case n <= 0xffff:
+		b = append(b, b1|126, byte(n>>8), byte(n))
+	default:
+		b = append(b, b1|127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	if !mask {
+		return append(b, payload...)
+	}
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, key[:]...)
+	for i, c := range payload {
+		b = append(b, c^key[i%4])
+	}
+	return b
+}
+
+// TestReaderMaxMessageSizeIgnoresControlFrames is a regression test for a
+// ping frame interleaved between the fragments of a message inflating the
+// cumulative size accounting: the message's own fragments total well under
+// MaxMessageSize, but an interleaved control frame larger than the
+// remaining budget used to trip ErrMessageTooLarge anyway.
+func TestReaderMaxMessageSizeIgnoresControlFrames(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(false, ws.OpText, bytes.Repeat([]byte{'a'}, 10), false))
+	wire.Write(buildFrame(true, ws.OpPing, bytes.Repeat([]byte{'p'}, 20), false))
+	wire.Write(buildFrame(true, ws.OpContinuation, bytes.Repeat([]byte{'b'}, 10), false))
+
+	r := NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+	r.MaxMessageSize = 25
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := "aaaaaaaaaabbbbbbbbbb"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// TestReaderMaxMessageSizeStillEnforcedForDataFrames guards against the fix
+// above going too far: non-control frames must still count toward the
+// limit.
+func TestReaderMaxMessageSizeStillEnforcedForDataFrames(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(false, ws.OpText, bytes.Repeat([]byte{'a'}, 10), false))
+	wire.Write(buildFrame(true, ws.OpContinuation, bytes.Repeat([]byte{'b'}, 10), false))
+
+	r := NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+	r.MaxMessageSize = 15
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame (first fragment): %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("reading first fragment: %v", err)
+	}
+	if _, err := r.NextFrame(); err != ErrMessageTooLarge {
+		t.Fatalf("got err %v, want ErrMessageTooLarge", err)
+	}
+}
+
+// TestNextReaderFromReaderDetectsStaleRead is a regression test for the
+// stale-reader guard: calling NextReaderFromReader again on the same Reader
+// before draining the io.Reader from a previous call must make that
+// previous io.Reader return ErrReaderClosed, rather than silently observing
+// whatever bytes the Reader has moved on to.
+func TestNextReaderFromReaderDetectsStaleRead(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(true, ws.OpText, []byte("first-message-payload"), false))
+	wire.Write(buildFrame(true, ws.OpText, []byte("second-message-payload"), false))
+
+	rd := NewReader(&wire, ws.StateServerSide)
+	rd.SkipHeaderCheck = true
+
+	_, r1, err := NextReaderFromReader(rd)
+	if err != nil {
+		t.Fatalf("first NextReaderFromReader: %v", err)
+	}
+
+	// Advance rd again before r1 has been drained. Regardless of what (if
+	// anything) this second call manages to parse out of the first
+	// message's still-unread payload bytes, rd's seq has moved on, so r1
+	// must be considered stale from here on.
+	_, _, _ = NextReaderFromReader(rd)
+
+	if _, err := r1.Read(make([]byte, 4)); err != ErrReaderClosed {
+		t.Fatalf("got err %v, want ErrReaderClosed", err)
+	}
+}
+
+// TestNextReaderBackwardCompatibleSignature guards against NextReader's
+// exported (io.Reader, ws.State) signature being changed again: it must
+// keep working without a caller-held Reader, same as before
+// NextReaderFromReader was introduced.
+func TestNextReaderBackwardCompatibleSignature(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(true, ws.OpText, []byte("hello"), false))
+
+	hdr, r, err := NextReader(&wire, ws.StateClientSide)
+	if err != nil {
+		t.Fatalf("NextReader: %v", err)
+	}
+	if hdr.OpCode != ws.OpText {
+		t.Fatalf("got opcode %v, want OpText", hdr.OpCode)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+// TestNextReaderWithHandlersInvokesCallbacks checks that
+// NextReaderWithHandlers delivers an interleaved control frame to
+// onIntermediate and a continuation frame to onContinuation, and that the
+// message payload reads back whole across the interruption.
+func TestNextReaderWithHandlersInvokesCallbacks(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(false, ws.OpText, []byte("hello "), false))
+	wire.Write(buildFrame(true, ws.OpPing, []byte("ping-payload"), false))
+	wire.Write(buildFrame(true, ws.OpContinuation, []byte("world"), false))
+
+	var intermediateOpCode ws.OpCode
+	var continuationSeen bool
+	onIntermediate := func(hdr ws.Header, r io.Reader) error {
+		intermediateOpCode = hdr.OpCode
+		_, err := ioutil.ReadAll(r)
+		return err
+	}
+	onContinuation := func(hdr ws.Header, r io.Reader) error {
+		continuationSeen = true
+		return nil
+	}
+
+	_, r, err := NextReaderWithHandlers(&wire, ws.StateClientSide, onIntermediate, onContinuation)
+	if err != nil {
+		t.Fatalf("NextReaderWithHandlers: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := "hello world"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if intermediateOpCode != ws.OpPing {
+		t.Fatalf("onIntermediate not called with the ping frame, got opcode %v", intermediateOpCode)
+	}
+	if !continuationSeen {
+		t.Fatalf("onContinuation was not called")
+	}
+}
+
+// TestReaderFragmentedEOFIsUnexpected checks that a source that ends before
+// a fragmented message's Fin frame arrives surfaces io.ErrUnexpectedEOF
+// rather than a plain io.EOF that would let callers like ioutil.ReadAll
+// mistake a truncated message for a complete one.
+func TestReaderFragmentedEOFIsUnexpected(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(buildFrame(false, ws.OpText, []byte("partial"), false))
+
+	r := NewReader(&wire, ws.StateServerSide)
+	r.SkipHeaderCheck = true
+
+	if _, err := r.NextFrame(); err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got err %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,200 @@
+package wsutil
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrInvalidUTF8 is returned by UTF8Reader, and by Reader when CheckUTF8 is
+// enabled, once invalid UTF-8 bytes are observed in a text message.
+var ErrInvalidUTF8 = errors.New("wsutil: invalid utf8")
+
+// CheckUTF8Mode controls when a UTF8Reader reports invalid UTF-8.
+type CheckUTF8Mode int
+
+const (
+	// UTF8Deferred buffers validity across the whole message: Read() never
+	// fails because of invalid UTF-8, and the caller is expected to check
+	// Valid() (and use Accepted() to truncate the last chunk) once the
+	// message has been fully read. This is the zero value, matching the
+	// package's historical behavior.
+	UTF8Deferred CheckUTF8Mode = iota
+
+	// UTF8Streaming validates bytes as soon as they are read and returns
+	// ErrInvalidUTF8 from the first Read() call that observes an invalid
+	// byte, rather than waiting for the whole message to arrive.
+	UTF8Streaming
+)
+
+// UTF8Reader implements io.Reader, validating that bytes read from Source
+// form valid UTF-8 sequences.
+//
+// Validation is done with a DFA: every byte is first mapped to one of a
+// small number of classes (ASCII, a handful of continuation-byte ranges,
+// and one per multi-byte lead byte), and the class is combined with the
+// reader's current state to look up the next state. Only an integer state
+// needs to persist between Read() calls, so a multi-byte sequence that
+// straddles a Read() (or frame) boundary is validated correctly without
+// buffering any bytes, and ASCII-heavy payloads stay allocation-free and
+// branch-light.
+type UTF8Reader struct {
+	Source io.Reader
+
+	// Mode selects when invalid UTF-8 is reported. The zero value is
+	// UTF8Deferred.
+	Mode CheckUTF8Mode
+
+	state    uint8
+	accepted int
+}
+
+// Read implements io.Reader.
+//
+// In UTF8Deferred mode (the default), Read() never returns ErrInvalidUTF8
+// itself; call Valid() once the source is exhausted to check the whole
+// message, and Accepted() to find out how many bytes of the last Read()
+// belonged to a valid prefix.
+//
+// In UTF8Streaming mode, Read() returns ErrInvalidUTF8 (together with the
+// number of valid bytes it could still deliver) as soon as an invalid byte
+// is seen.
+func (r *UTF8Reader) Read(p []byte) (n int, err error) {
+	n, err = r.Source.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	state := r.state
+	accepted := 0
+	for i := 0; i < n; i++ {
+		state = utf8Transitions[state][utf8ClassOf[p[i]]]
+		if state == utf8Reject {
+			break
+		}
+		if state == utf8Accept {
+			accepted = i + 1
+		}
+	}
+	r.state = state
+	r.accepted = accepted
+
+	if state == utf8Reject && r.Mode == UTF8Streaming {
+		return accepted, ErrInvalidUTF8
+	}
+	return n, err
+}
+
+// Valid reports whether every byte read so far forms a complete, valid
+// UTF-8 sequence: no invalid byte was seen, and no multi-byte sequence is
+// left dangling at the end of the message.
+func (r *UTF8Reader) Valid() bool {
+	return r.state == utf8Accept
+}
+
+// Accepted returns the number of bytes from the most recent Read() call
+// that were confirmed to be part of a valid sequence. Callers use it to
+// truncate a Read() whose trailing bytes turned out to belong to an
+// invalid sequence.
+func (r *UTF8Reader) Accepted() int {
+	return r.accepted
+}
+
+// Byte classes used by the UTF-8 DFA below. Continuation-byte ranges are
+// split finely enough to reject overlong encodings, UTF-16 surrogates, and
+// codepoints above U+10FFFF purely through the state transitions, without
+// any extra branching in Read().
+const (
+	utf8cASCII      = iota // 0x00-0x7F
+	utf8c80_8F             // 0x80-0x8F
+	utf8c90_9F             // 0x90-0x9F
+	utf8cA0_BF             // 0xA0-0xBF
+	utf8cC0_C1             // 0xC0-0xC1, never valid as a lead byte
+	utf8cC2_DF             // 0xC2-0xDF, 2-byte sequence lead
+	utf8cE0                // 0xE0, 3-byte sequence lead (excludes overlong)
+	utf8cE1_EC_EE_EF       // 0xE1-0xEC, 0xEE-0xEF, 3-byte sequence lead
+	utf8cED                // 0xED, 3-byte sequence lead (excludes surrogates)
+	utf8cF0                // 0xF0, 4-byte sequence lead (excludes overlong)
+	utf8cF1_F3             // 0xF1-0xF3, 4-byte sequence lead
+	utf8cF4                // 0xF4, 4-byte sequence lead (excludes > U+10FFFF)
+	utf8cF5_FF             // 0xF5-0xFF, never valid as a lead byte
+	utf8numClasses
+)
+
+// States of the UTF-8 DFA. Besides the terminal utf8Accept/utf8Reject
+// states, each "First" state tracks that we are waiting for the first
+// continuation byte of a multi-byte sequence and what range it must fall
+// in, while utf8Expect1/utf8Expect2 track trailing continuation bytes that
+// are always unrestricted (0x80-0xBF).
+const (
+	utf8Accept = iota
+	utf8Reject
+	utf8Expect1    // one more continuation byte needed
+	utf8Expect2    // two more continuation bytes needed
+	utf8E0First    // after 0xE0: next byte must be 0xA0-0xBF
+	utf8EDFirst    // after 0xED: next byte must be 0x80-0x9F
+	utf8Lead3First // after 0xE1-0xEC/0xEE-0xEF: next byte must be 0x80-0xBF
+	utf8F0First    // after 0xF0: next byte must be 0x90-0xBF
+	utf8F4First    // after 0xF4: next byte must be 0x80-0x8F
+	utf8Lead4First // after 0xF1-0xF3: next byte must be 0x80-0xBF
+	utf8numStates
+)
+
+var utf8ClassOf [256]uint8
+
+var utf8Transitions [utf8numStates][utf8numClasses]uint8
+
+func init() {
+	fillClass := func(c uint8, lo, hi byte) {
+		for b := int(lo); b <= int(hi); b++ {
+			utf8ClassOf[b] = c
+		}
+	}
+	fillClass(utf8cASCII, 0x00, 0x7F)
+	fillClass(utf8c80_8F, 0x80, 0x8F)
+	fillClass(utf8c90_9F, 0x90, 0x9F)
+	fillClass(utf8cA0_BF, 0xA0, 0xBF)
+	fillClass(utf8cC0_C1, 0xC0, 0xC1)
+	fillClass(utf8cC2_DF, 0xC2, 0xDF)
+	fillClass(utf8cE0, 0xE0, 0xE0)
+	fillClass(utf8cE1_EC_EE_EF, 0xE1, 0xEC)
+	fillClass(utf8cE1_EC_EE_EF, 0xEE, 0xEF)
+	fillClass(utf8cED, 0xED, 0xED)
+	fillClass(utf8cF0, 0xF0, 0xF0)
+	fillClass(utf8cF1_F3, 0xF1, 0xF3)
+	fillClass(utf8cF4, 0xF4, 0xF4)
+	fillClass(utf8cF5_FF, 0xF5, 0xFF)
+
+	// Default every transition to reject; set the valid ones below. This
+	// also makes utf8Reject an absorbing state for free.
+	for s := 0; s < utf8numStates; s++ {
+		for c := 0; c < utf8numClasses; c++ {
+			utf8Transitions[s][c] = utf8Reject
+		}
+	}
+
+	cont := []uint8{utf8c80_8F, utf8c90_9F, utf8cA0_BF}
+	setAll := func(state uint8, classes []uint8, next uint8) {
+		for _, c := range classes {
+			utf8Transitions[state][c] = next
+		}
+	}
+
+	utf8Transitions[utf8Accept][utf8cASCII] = utf8Accept
+	utf8Transitions[utf8Accept][utf8cC2_DF] = utf8Expect1
+	utf8Transitions[utf8Accept][utf8cE0] = utf8E0First
+	utf8Transitions[utf8Accept][utf8cE1_EC_EE_EF] = utf8Lead3First
+	utf8Transitions[utf8Accept][utf8cED] = utf8EDFirst
+	utf8Transitions[utf8Accept][utf8cF0] = utf8F0First
+	utf8Transitions[utf8Accept][utf8cF1_F3] = utf8Lead4First
+	utf8Transitions[utf8Accept][utf8cF4] = utf8F4First
+
+	setAll(utf8Expect1, cont, utf8Accept)
+	setAll(utf8Expect2, cont, utf8Expect1)
+	setAll(utf8Lead3First, cont, utf8Expect1)
+	setAll(utf8Lead4First, cont, utf8Expect2)
+
+	utf8Transitions[utf8E0First][utf8cA0_BF] = utf8Expect1
+	setAll(utf8EDFirst, []uint8{utf8c80_8F, utf8c90_9F}, utf8Expect1)
+	setAll(utf8F0First, []uint8{utf8c90_9F, utf8cA0_BF}, utf8Expect2)
+	utf8Transitions[utf8F4First][utf8c80_8F] = utf8Expect2
+}
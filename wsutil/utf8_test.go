@@ -0,0 +1,72 @@
+package wsutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestUTF8ReaderStreamingRejectsInvalidByte(t *testing.T) {
+	r := &UTF8Reader{
+		Source: bytes.NewReader([]byte{0x41, 0xff, 0x42}),
+		Mode:   UTF8Streaming,
+	}
+
+	n, err := r.Read(make([]byte, 16))
+	if err != ErrInvalidUTF8 {
+		t.Fatalf("got err %v, want ErrInvalidUTF8", err)
+	}
+	if n != 1 {
+		t.Fatalf("got n=%d, want 1 (only the leading ASCII byte is valid)", n)
+	}
+}
+
+// chunkedReader delivers one byte slice per Read call, simulating a
+// multi-byte UTF-8 sequence arriving split across reads, such as across two
+// WS frames of the same message.
+type chunkedReader struct {
+	chunks [][]byte
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+// TestUTF8ReaderBoundarySplitSequence checks that a valid multi-byte
+// sequence straddling a Read() boundary is not mistaken for invalid UTF-8:
+// the DFA state must persist correctly between calls.
+func TestUTF8ReaderBoundarySplitSequence(t *testing.T) {
+	// "é" is the two-byte sequence 0xC3 0xA9, delivered one byte per Read.
+	r := &UTF8Reader{
+		Source: &chunkedReader{chunks: [][]byte{{0xC3}, {0xA9}}},
+		Mode:   UTF8Streaming,
+	}
+	buf := make([]byte, 16)
+
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error on lead byte: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("got n=%d, want 1", n)
+	}
+	if r.Valid() {
+		t.Fatalf("reader reports valid with a sequence still dangling")
+	}
+
+	n, err = r.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error on trailing continuation byte: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("got n=%d, want 1", n)
+	}
+	if !r.Valid() {
+		t.Fatalf("reader does not report valid once the sequence completes")
+	}
+}